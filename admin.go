@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// backendView is the JSON shape returned by the admin API for a backend.
+type backendView struct {
+	URL       string `json:"url"`
+	Alive     bool   `json:"alive"`
+	Draining  bool   `json:"draining"`
+	Health    string `json:"health"`
+	Flow      uint64 `json:"flow"`
+	Capacity  int    `json:"capacity"`
+	PoolIdle  int    `json:"pool_idle"`
+	PoolInUse int    `json:"pool_in_use"`
+}
+
+func newBackendView(b *Backend) backendView {
+	pm := b.PoolMetrics()
+	return backendView{
+		URL:       b.URL.String(),
+		Alive:     b.IsAlive(),
+		Draining:  b.IsDraining(),
+		Health:    b.HealthState().String(),
+		Flow:      b.Flow(),
+		Capacity:  b.capacity,
+		PoolIdle:  pm.Idle,
+		PoolInUse: pm.InUse,
+	}
+}
+
+// addBackendRequest is the JSON body for POST /backends.
+type addBackendRequest struct {
+	URL        string `json:"url"`
+	Capacity   int    `json:"capacity"`
+	Weight     int    `json:"weight"`
+	HealthPath string `json:"health_path"`
+}
+
+// backendActionRequest is the JSON body for the drain/remove/recheck
+// endpoints, all of which just need to identify a backend (and, for drain,
+// how long to wait).
+type backendActionRequest struct {
+	URL       string `json:"url"`
+	TimeoutMs int    `json:"timeout_ms"`
+}
+
+// NewAdminServer builds the admin HTTP server listening on addr, exposing
+// JSON endpoints to list, add, drain, remove and recheck backends.
+func NewAdminServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/backends", handleBackends)
+	mux.HandleFunc("/backends/drain", handleDrainBackend)
+	mux.HandleFunc("/backends/remove", handleRemoveBackend)
+	mux.HandleFunc("/backends/recheck", handleRecheckBackend)
+	mux.HandleFunc("/metrics", handleMetrics)
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.WriteTo(w, servers.List())
+}
+
+func handleBackends(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list := servers.List()
+		views := make([]backendView, 0, len(list))
+		for _, b := range list {
+			views = append(views, newBackendView(b))
+		}
+		writeJSON(w, http.StatusOK, views)
+	case http.MethodPost:
+		var req addBackendRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		serverURL, err := url.Parse(req.URL)
+		if err != nil {
+			http.Error(w, "invalid url: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if servers.Find(serverURL) != nil {
+			http.Error(w, "backend already exists", http.StatusConflict)
+			return
+		}
+		hcConfig := HealthCheckConfigFile{Path: req.HealthPath}.HealthCheckConfig(DefaultHealthCheckConfig())
+		backend := newConfiguredBackend(serverURL, req.Capacity, req.Weight, hcConfig)
+		servers.AddBackend(backend)
+		go PrewarmPool(httpClient, backend, prewarmConns)
+		writeJSON(w, http.StatusCreated, newBackendView(backend))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleDrainBackend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req backendActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	serverURL, err := url.Parse(req.URL)
+	if err != nil {
+		http.Error(w, "invalid url: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	backend := servers.Find(serverURL)
+	if backend == nil {
+		http.Error(w, "backend not found", http.StatusNotFound)
+		return
+	}
+	timeout := time.Duration(req.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	drained := backend.Drain(timeout)
+	writeJSON(w, http.StatusOK, map[string]bool{"drained": drained})
+}
+
+func handleRemoveBackend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req backendActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	serverURL, err := url.Parse(req.URL)
+	if err != nil {
+		http.Error(w, "invalid url: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !servers.RemoveBackend(serverURL) {
+		http.Error(w, "backend not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"removed": true})
+}
+
+func handleRecheckBackend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req backendActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	serverURL, err := url.Parse(req.URL)
+	if err != nil {
+		http.Error(w, "invalid url: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	backend := servers.Find(serverURL)
+	if backend == nil {
+		http.Error(w, "backend not found", http.StatusNotFound)
+		return
+	}
+	if backend.checker != nil {
+		backend.checker.Recheck(r.Context())
+	}
+	writeJSON(w, http.StatusOK, newBackendView(backend))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -2,25 +2,71 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"os/signal"
 	"sync"
-	"sync/atomic"
+	"syscall"
 	"time"
 )
 
-//backend holds the data about the server
+// backend holds the data about the server
 type Backend struct {
 	sync.RWMutex
 	URL      *url.URL
 	Alive    bool                   //is the server active?
+	Draining bool                   //true once the admin API asked this backend to stop taking new traffic
 	Proxy    *httputil.ReverseProxy //the proxy in what we are going to redirect the request
-	capacity int                    //capacity is used for sending more requests or less
+	capacity int                    //capacity is used for gating in-flight requests
+	weight   int                    //weight used by WeightedRoundRobinPolicy
 	flow     uint64                 //how much request are you handling now?
+	checker  *HealthChecker         //active+passive health state machine for this backend
+	sem      chan struct{}          //bounded semaphore gating in-flight requests to capacity
+}
+
+// NewBackend builds a Backend for serverURL proxying through proxy, with its
+// in-flight requests bounded to capacity and its weighted-round-robin
+// weight set to weight. Both are clamped to at least 1.
+func NewBackend(serverURL *url.URL, proxy *httputil.ReverseProxy, capacity, weight int) *Backend {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	return &Backend{
+		URL:      serverURL,
+		Proxy:    proxy,
+		capacity: capacity,
+		weight:   weight,
+		sem:      make(chan struct{}, capacity),
+	}
+}
+
+// SetWeight updates the backend's weighted-round-robin weight. This can be
+// applied live (e.g. on config reload) without disturbing in-flight
+// requests or the connection pool.
+func (b *Backend) SetWeight(weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	b.Lock()
+	b.weight = weight
+	b.Unlock()
+}
+
+// HealthState returns the backend's current health-checker verdict, for use
+// by status/metrics endpoints. It's StateUnknown if no checker is attached.
+func (b *Backend) HealthState() HealthState {
+	if b.checker == nil {
+		return StateUnknown
+	}
+	return b.checker.State()
 }
 
 func (b *Backend) SetAlive(alive bool) {
@@ -37,7 +83,40 @@ func (b *Backend) IsAlive() (alive bool) {
 	return
 }
 
-//how much space free have the backend?
+// SetDraining marks the backend as draining (or not). A draining backend
+// keeps serving requests it already holds but is skipped by selection.
+func (b *Backend) SetDraining(draining bool) {
+	b.Lock()
+	b.Draining = draining
+	b.Unlock()
+}
+
+// IsDraining reports whether the backend is draining.
+func (b *Backend) IsDraining() (draining bool) {
+	b.Lock()
+	draining = b.Draining
+	b.Unlock()
+	return
+}
+
+// Drain marks the backend as draining and blocks until its in-flight
+// requests reach zero or timeout elapses. It returns whether the backend
+// fully drained in time.
+func (b *Backend) Drain(timeout time.Duration) bool {
+	b.SetDraining(true)
+	deadline := time.Now().Add(timeout)
+	for {
+		if b.Flow() == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return b.Flow() == 0
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// how much space free have the backend?
 func (b *Backend) Free() (free int) {
 	b.Lock()
 	free = b.capacity - int(b.flow)
@@ -45,177 +124,336 @@ func (b *Backend) Free() (free int) {
 	return
 }
 
-//servers holds information about servers
+// Flow returns how many requests the backend is currently handling.
+func (b *Backend) Flow() uint64 {
+	b.Lock()
+	flow := b.flow
+	b.Unlock()
+	return flow
+}
+
+// servers holds information about servers, guarded by mu so backends can be
+// added/removed while GetNextBackend is reading the slice concurrently.
 type Servers struct {
+	mu       sync.RWMutex
 	backends []*Backend
-	current  uint64
+	policy   SelectionPolicy
 }
 
-//add a backend to servers
-func (s *Servers) AddBackend(backend *Backend) {
-	s.backends = append(s.backends, backend)
-	idx := len(s.backends)
-	for s.backends[idx].capacity > s.backends[idx-1].capacity && idx != 0 {
-		s.backends[idx-1], s.backends[idx] = s.backends[idx], s.backends[idx-1]
+// NewServers creates a Servers using the given selection policy. Callers
+// that don't care about the policy yet (tests, early wiring) can pass nil
+// and it defaults to round robin.
+func NewServers(policy SelectionPolicy) *Servers {
+	if policy == nil {
+		policy = &RoundRobinPolicy{}
 	}
+	return &Servers{policy: policy}
 }
 
-//get the next index
-func (s *Servers) NextIndex(value *uint64) int {
-	return int(atomic.AddUint64(&s.current, uint64(1)) % uint64(len(s.backends)))
+// add a backend to servers
+func (s *Servers) AddBackend(backend *Backend) {
+	s.mu.Lock()
+	s.backends = append(s.backends, backend)
+	s.mu.Unlock()
 }
 
-//changes the status of a backend
-func (s *Servers) MarkBackendStatus(backendUrl *url.URL, alive bool) {
+// Find returns the backend matching backendUrl, or nil if there isn't one.
+func (s *Servers) Find(backendUrl *url.URL) *Backend {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	for _, backend := range s.backends {
 		if backend.URL.String() == backendUrl.String() {
-			backend.SetAlive(alive)
+			return backend
 		}
 	}
+	return nil
 }
 
-func (s *Servers) GetNextBackend() *Backend {
-	next := 0
-	//get the first avaiable backend in the list (the list are ordered by capacity free)
-	for !s.backends[next].IsAlive() {
-		next++
-	}
-	//we have the best avaiable backend
-	defer s.Tidy(next)
-	if next != 0 {
-		atomic.StoreUint64(&s.current, uint64(next))
+// RemoveBackend drops backendUrl from the pool, stops its health checker so
+// the probe goroutine doesn't leak, and reports whether it was present.
+// Callers that want in-flight requests to finish first should Drain the
+// backend before calling this.
+func (s *Servers) RemoveBackend(backendUrl *url.URL) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, backend := range s.backends {
+		if backend.URL.String() == backendUrl.String() {
+			s.backends = append(s.backends[:i], s.backends[i+1:]...)
+			if backend.checker != nil {
+				backend.checker.Stop()
+			}
+			return true
+		}
 	}
-	return s.backends[next]
+	return false
 }
 
-func (s *Servers) Tidy(idx int) {
-	l := len(s.backends)
-	for ; s.backends[idx].Free() > s.backends[idx+1].Free() && idx+1 < l; idx-- {
-		s.backends[idx], s.backends[idx+1] = s.backends[idx+1], s.backends[idx]
+// List returns a snapshot of the current backends, for the admin API.
+func (s *Servers) List() []*Backend {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]*Backend, len(s.backends))
+	copy(list, s.backends)
+	return list
+}
+
+// DrainAll drains every backend concurrently, each bounded by timeout, and
+// waits for all of them to finish. Used on graceful shutdown.
+func (s *Servers) DrainAll(timeout time.Duration) {
+	backends := s.List()
+	var wg sync.WaitGroup
+	for _, b := range backends {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			b.Drain(timeout)
+		}(b)
 	}
+	wg.Wait()
 }
 
-func (s *Servers) HealthCheck() {
+// GetNextBackend asks the configured SelectionPolicy to choose a backend
+// for r among the currently alive, non-full, non-draining ones. It returns
+// nil if none qualify.
+func (s *Servers) GetNextBackend(r *http.Request) *Backend {
+	s.mu.RLock()
+	policy := s.policy
+	alive := make([]*Backend, 0, len(s.backends))
 	for _, b := range s.backends {
-		status := "up"
-		alive := isBackendAlive(b.URL)
-		b.SetAlive(alive)
-		if !alive {
-			status = "down"
+		if b.IsAlive() && !b.IsFull() && !b.IsDraining() {
+			alive = append(alive, b)
 		}
-		log.Printf("%s [%s]\n", b.URL, status)
 	}
-}
-
-func isBackendAlive(u *url.URL) bool {
-	timeout := 1 * time.Second
-	conn, err := net.DialTimeout("tcp", u.Host, timeout)
-	defer conn.Close()
-	if err != nil {
-		log.Println("site unreachable, error: ", err)
-		return false
+	s.mu.RUnlock()
+	backend := policy.Select(r, alive)
+	if backend != nil {
+		metrics.ObserveSelection(policy.Name(), backend.URL.Host)
 	}
-	return true
+	return backend
 }
 
-//get attempts returns the attempts for request
-func GetAttemptsFromContext(r *http.Request) int {
-	if attempts, ok := r.Context().Value("Attempts").(int); ok {
-		return attempts
-	}
-	return 1
+// SetPolicy swaps the active SelectionPolicy, e.g. on a config reload. It's
+// guarded by mu so it's safe to call concurrently with GetNextBackend.
+func (s *Servers) SetPolicy(policy SelectionPolicy) {
+	s.mu.Lock()
+	s.policy = policy
+	s.mu.Unlock()
 }
 
-func GetRetryFromContext(r *http.Request) int {
-	if retries, ok := r.Context().Value("Retry").(int); ok {
-		return retries
+// this is the load balancer that balances the load of the server
+func lb(w http.ResponseWriter, r *http.Request) {
+	rp := retryPolicy.Get()
+	attempt := AttemptsFromContext(r.Context())
+	if attempt >= rp.MaxRetries {
+		requestLogger.Warn("max attempts reached", "client_ip", r.RemoteAddr, "path", r.URL.Path, "attempt", attempt)
+		http.Error(w, "service not avaiable", http.StatusServiceUnavailable)
+		return
+	}
+	if _, ok := TryDeadlineFromContext(r.Context()); !ok {
+		r = r.WithContext(withTryDeadline(r.Context(), time.Now().Add(rp.TryDuration)))
 	}
-	return 0
-}
 
-//this is the load balancer that balances the load of the server
-func lb(w http.ResponseWriter, r *http.Request) {
-	attempts := GetAttemptsFromContext(r)
-	if attempts > 3 {
-		log.Printf("%s(%s) Max attempts reached, termitating service", r.RemoteAddr, r.URL.Path)
+	backend := servers.GetNextBackend(r)
+	if backend == nil {
 		http.Error(w, "service not avaiable", http.StatusServiceUnavailable)
 		return
 	}
-	backend := servers.GetNextBackend()
-	if backend != nil {
-		backend.Proxy.ServeHTTP(w, r)
-		fmt.Println(servers.backends)
+	if !backend.TryAcquire() {
+		// GetNextBackend filtered on !IsFull(), but another request can win
+		// the backend's last capacity slot in the gap between that check
+		// and TryAcquire. Treat the loss like an exhausted backend rather
+		// than failing the request outright - another backend may still
+		// have room.
+		if deadline, ok := TryDeadlineFromContext(r.Context()); ok && time.Now().After(deadline) {
+			http.Error(w, "service not avaiable", http.StatusGatewayTimeout)
+			return
+		}
+		handOffToAnotherBackend(w, r)
 		return
 	}
-	http.Error(w, "service not avaiable", http.StatusServiceUnavailable)
+	defer backend.Release()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	start := time.Now()
+	backend.Proxy.ServeHTTP(rec, r)
+	latency := time.Since(start)
+
+	metrics.ObserveRequest(backend.URL.Host, r.Method, rec.status, latency)
+	requestLogger.Info("request",
+		"backend", backend.URL.Host,
+		"attempt", attempt+1,
+		"status", rec.status,
+		"latency_ms", latency.Milliseconds(),
+		"client_ip", r.RemoteAddr,
+	)
 }
 
-var servers Servers
+// handOffToAnotherBackend bumps the request's attempt counter, resets its
+// per-backend retry count, and re-enters lb so a different backend can pick
+// it up. Both DefaultErrorHandler (once its retries against one backend are
+// exhausted) and lb (when the chosen backend loses a TryAcquire race) give up
+// on the current backend this way.
+func handOffToAnotherBackend(w http.ResponseWriter, r *http.Request) {
+	attempts := AttemptsFromContext(r.Context())
+	ctx := withRetry(withAttempts(r.Context(), attempts+1), 0)
+	lb(w, r.WithContext(ctx))
+}
+
+var servers = NewServers(nil)
+var retryPolicy = newRetryPolicyHolder(DefaultRetryPolicy())
+var retryBudget = NewRetryBudget(50, 10)
+var transport = NewTransport(DefaultTransportConfig())
+var httpClient = &http.Client{Transport: transport}
+var prewarmConns = 5
+
+// newConfiguredBackend builds a Backend for serverURL wired up with the
+// shared transport, the current retryPolicy's error handler, and an active
+// health checker using hcConfig. It's used both for the backends
+// configured at startup and for ones added later through the admin API or
+// a config reload.
+func newConfiguredBackend(serverURL *url.URL, capacity, weight int, hcConfig HealthCheckConfig) *Backend {
+	proxy := httputil.NewSingleHostReverseProxy(serverURL)
+	proxy.Transport = transport
+
+	backend := NewBackend(serverURL, proxy, capacity, weight)
+	proxy.ErrorHandler = DefaultErrorHandler(serverURL, backend)
+	proxy.ModifyResponse = RetryableStatusChecker(serverURL)
+	backend.checker = NewHealthChecker(backend, hcConfig)
+	backend.checker.Start(context.Background())
+	return backend
+}
+
+// RetryableStatusChecker builds the httputil.ReverseProxy.ModifyResponse for
+// serverUrl's backend. A plain HTTP response (no transport error) never
+// reaches ErrorHandler on its own, so if the upstream's status is in the
+// current retryPolicy's RetryableStatusCodes, this turns it into an error -
+// which routes it through ErrorHandler's retry logic exactly like a
+// transport-level failure would.
+func RetryableStatusChecker(serverUrl *url.URL) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if retryPolicy.Get().IsRetryableStatus(resp.StatusCode) {
+			return fmt.Errorf("upstream %s returned retryable status %d", serverUrl.Host, resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// DefaultErrorHandler builds the httputil.ReverseProxy.ErrorHandler for
+// backend. On a proxy error it reports a passive health-check failure to
+// backend.checker - which flips Alive only once its unhealthy threshold is
+// reached, the same as an active probe would - retries against the same
+// backend a few times with backoff while the shared retryBudget has tokens,
+// and once those are exhausted (or the budget is empty) hands the request
+// back to lb to pick another one. Both are bounded by retryPolicy's
+// try-duration; hand-offs to another backend are limited by MaxRetries and
+// do not draw on retryBudget.
+func DefaultErrorHandler(serverUrl *url.URL, backend *Backend) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("[%s] %s\n", serverUrl.Host, err.Error())
+		metrics.ObserveRetry(serverUrl.Host)
+		if backend.checker != nil {
+			backend.checker.ReportFailure()
+		}
+
+		rp := retryPolicy.Get()
+		if !rp.IsRetryableMethod(r.Method) {
+			http.Error(w, "service not avaiable", http.StatusBadGateway)
+			return
+		}
+		if deadline, ok := TryDeadlineFromContext(r.Context()); ok && time.Now().After(deadline) {
+			http.Error(w, "service not avaiable", http.StatusGatewayTimeout)
+			return
+		}
 
-func DefaultErrorHandler(serverUrl url.URL) func() (http.ResponseWriter, http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("[%s] %s\n", serverUrl.Host, e.Error())
-		retries := GetRetryFromContext(r)
-		if retries < 3 {
-			//if retries are less than 3 wee can try it again
+		retries := RetryFromContext(r.Context())
+		if retries < rp.MaxRetries && retryBudget.Allow() {
+			//if retries are less than the policy allows we can try it again
 			select {
-			case <-time.After(10 * time.Millisecond):
-				ctx := context.WithValue(request.Context(), Retry, retries)
-				proxy.ServeHTTP(r, request.WithContext(ctx))
+			case <-time.After(rp.TryInterval):
+				ctx := withRetry(r.Context(), retries+1)
+				backend.Proxy.ServeHTTP(w, r.WithContext(ctx))
+			case <-r.Context().Done():
 			}
 			return
 		}
-		servers.MarckBackendStatus(serverUrl, false)
-		// if the same request routing for few attempts with different backends, increase the count
-		attempts := GetAttemptsFromContext(request)
-		log.Printf("%s(%s) Attempting retry %d\n", request.RemoteAddr, request.URL.Path, attempts)
-		ctx := context.WithValue(request.Context(), Attempts, attempts+1)
-		lb(writer, request.WithContext(ctx))
+
+		// retries on this backend are exhausted, hand the request to another one
+		log.Printf("%s(%s) Attempting retry %d\n", r.RemoteAddr, r.URL.Path, AttemptsFromContext(r.Context()))
+		handOffToAnotherBackend(w, r)
 	}
 }
 
 func main() {
-	//var serverList string
-	//var port int
-	//reader := bufio.NewReader(os.Stdin)
-	fmt.Println("how much servers do you want to use?")
-	var nservers int
-	ports := make([]string, 0)
-	var port string
-	_, err := fmt.Scanf("%d", &nservers)
-	for ; nservers != 0 && err == nil; nservers-- {
-		fmt.Scanf("%s\n", &port)
-		ports = append(ports, port)
-	}
-
-	for uri := range ports {
-		serverURL, err := url.Parse(uri)
-		if err != nil {
-			log.Fatal(err)
-		}
+	startingRetryPolicy := retryPolicy.Get()
+	configPath := flag.String("config", "", "path to the balancer config file (required)")
+	policyName := flag.String("policy", "round-robin", "default selection policy, overridden by the config file's selection_policy")
+	prewarm := flag.Int("prewarm", 5, "keep-alive connections to pre-establish per backend")
+	maxRetries := flag.Int("max-retries", startingRetryPolicy.MaxRetries, "backends to try before giving up")
+	tryDuration := flag.Duration("try-duration", startingRetryPolicy.TryDuration, "total wall-clock budget across all retries")
+	tryInterval := flag.Duration("try-interval", startingRetryPolicy.TryInterval, "backoff between attempts against the same backend")
+	adminListen := flag.String("admin-listen", ":9000", "default admin API address, overridden by the config file's admin_listen")
+	flag.Parse()
 
-		proxy := httputil.NewSingleHostReverseProxy(serverURL)
-		proxy.ErrorHandler = DefaultErrorHandler(serverURL)
+	if *configPath == "" {
+		log.Fatal("-config is required (see config.go for the file format)")
+	}
+	startingRetryPolicy.MaxRetries = *maxRetries
+	startingRetryPolicy.TryDuration = *tryDuration
+	startingRetryPolicy.TryInterval = *tryInterval
+	retryPolicy.Set(startingRetryPolicy)
+	prewarmConns = *prewarm
 
-		servers.AddBackend(&Backend{
-			URL:   serverURL,
-			Alive: true,
-			Proxy: proxy,
-		})
-		log.Printf("Configured server: %s\n", serverURL)
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.SelectionPolicy == "" {
+		cfg.SelectionPolicy = *policyName
+	}
+	if cfg.AdminListen == "" {
+		cfg.AdminListen = *adminListen
+	}
+	if err := ApplyConfig(cfg, true); err != nil {
+		log.Fatal(err)
 	}
 
 	// create http server
-	server := http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
+	server := &http.Server{
+		Addr:    cfg.Listen,
 		Handler: http.HandlerFunc(lb),
 	}
 
-	// start health checking
-	go healthCheck()
+	adminServer := NewAdminServer(cfg.AdminListen)
+	go watchForReload(*configPath)
 
-	log.Printf("Load Balancer started at :%d\n", port)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal(err)
-	}
+	go func() {
+		log.Printf("Load Balancer started at %s\n", cfg.Listen)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	go func() {
+		log.Printf("Admin API started at %s\n", cfg.AdminListen)
+		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	waitForShutdown(server, adminServer)
+}
+
+// waitForShutdown blocks until SIGTERM/SIGINT, then gracefully shuts down
+// both HTTP servers and drains every backend before returning.
+func waitForShutdown(server, adminServer *http.Server) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	<-sigCh
+
+	log.Println("shutdown signal received, draining backends")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server.Shutdown(ctx)
+	adminServer.Shutdown(ctx)
+	servers.DrainAll(25 * time.Second)
+	log.Println("shutdown complete")
 }
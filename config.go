@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// BackendConfig describes one backend entry in the config file.
+type BackendConfig struct {
+	URL        string `json:"url"`
+	Weight     int    `json:"weight"`
+	Capacity   int    `json:"capacity"`
+	HealthPath string `json:"health_path"`
+}
+
+// RetryPolicyConfig mirrors RetryPolicy with durations as parseable strings
+// (e.g. "2s"), since that's how they're written in the config file.
+type RetryPolicyConfig struct {
+	MaxRetries  int    `json:"max_retries"`
+	TryDuration string `json:"try_duration"`
+	TryInterval string `json:"try_interval"`
+	// RetryableMethods overrides which HTTP methods are safe to retry
+	// automatically (GET/HEAD by default); set it to opt in POST etc.
+	RetryableMethods []string `json:"retryable_methods"`
+	// RetryableStatusCodes overrides which upstream statuses (e.g. 502/503/504)
+	// are retried, first against the same backend and then against another.
+	RetryableStatusCodes []int `json:"retryable_status_codes"`
+}
+
+// HealthCheckConfigFile mirrors HealthCheckConfig the same way.
+type HealthCheckConfigFile struct {
+	Path               string `json:"path"`
+	Method             string `json:"method"`
+	Interval           string `json:"interval"`
+	Timeout            string `json:"timeout"`
+	HealthyThreshold   int    `json:"healthy_threshold"`
+	UnhealthyThreshold int    `json:"unhealthy_threshold"`
+}
+
+// Config is the top-level shape of the -config file.
+type Config struct {
+	Listen          string                `json:"listen"`
+	AdminListen     string                `json:"admin_listen"`
+	SelectionPolicy string                `json:"selection_policy"`
+	RetryPolicy     RetryPolicyConfig     `json:"retry_policy"`
+	HealthCheck     HealthCheckConfigFile `json:"health_check"`
+	Backends        []BackendConfig       `json:"backends"`
+}
+
+// LoadConfig reads and validates a config file from path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate reports every problem with the config at once (malformed
+// backend URLs, duplicate backends, zero capacity), so the operator gets
+// one clear error instead of fixing issues one at a time.
+func (c *Config) Validate() error {
+	var problems []string
+	if c.Listen == "" {
+		problems = append(problems, "listen: must not be empty")
+	}
+	if len(c.Backends) == 0 {
+		problems = append(problems, "backends: at least one backend is required")
+	}
+	seen := make(map[string]bool, len(c.Backends))
+
+	for i, b := range c.Backends {
+		u, err := url.Parse(b.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("backend %d: invalid url %q", i, b.URL))
+			continue
+		}
+		if seen[u.String()] {
+			problems = append(problems, fmt.Sprintf("backend %d: duplicate backend %q", i, u.String()))
+		}
+		seen[u.String()] = true
+		if b.Capacity <= 0 {
+			problems = append(problems, fmt.Sprintf("backend %d (%s): capacity must be > 0", i, b.URL))
+		}
+	}
+
+	if _, err := NewSelectionPolicy(c.SelectionPolicy); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := parseDurationField("retry_policy.try_duration", c.RetryPolicy.TryDuration); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := parseDurationField("retry_policy.try_interval", c.RetryPolicy.TryInterval); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := parseDurationField("health_check.interval", c.HealthCheck.Interval); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := parseDurationField("health_check.timeout", c.HealthCheck.Timeout); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// parseDurationField parses an optional duration string, treating "" as
+// "not set" rather than an error.
+func parseDurationField(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return d, nil
+}
+
+// RetryPolicy converts the file's RetryPolicyConfig onto base, leaving
+// fields that weren't set in the file untouched.
+func (rc RetryPolicyConfig) RetryPolicy(base RetryPolicy) RetryPolicy {
+	if rc.MaxRetries > 0 {
+		base.MaxRetries = rc.MaxRetries
+	}
+	if d, _ := parseDurationField("retry_policy.try_duration", rc.TryDuration); d > 0 {
+		base.TryDuration = d
+	}
+	if d, _ := parseDurationField("retry_policy.try_interval", rc.TryInterval); d > 0 {
+		base.TryInterval = d
+	}
+	if len(rc.RetryableMethods) > 0 {
+		methods := make(map[string]bool, len(rc.RetryableMethods))
+		for _, m := range rc.RetryableMethods {
+			methods[strings.ToUpper(m)] = true
+		}
+		base.RetryableMethods = methods
+	}
+	if len(rc.RetryableStatusCodes) > 0 {
+		codes := make(map[int]bool, len(rc.RetryableStatusCodes))
+		for _, c := range rc.RetryableStatusCodes {
+			codes[c] = true
+		}
+		base.RetryableStatusCodes = codes
+	}
+	return base
+}
+
+// HealthCheckConfig converts the file's HealthCheckConfigFile onto base,
+// leaving fields that weren't set in the file untouched.
+func (hc HealthCheckConfigFile) HealthCheckConfig(base HealthCheckConfig) HealthCheckConfig {
+	if hc.Path != "" {
+		base.Path = hc.Path
+	}
+	if hc.Method != "" {
+		base.Method = hc.Method
+	}
+	if d, _ := parseDurationField("health_check.interval", hc.Interval); d > 0 {
+		base.Interval = d
+	}
+	if d, _ := parseDurationField("health_check.timeout", hc.Timeout); d > 0 {
+		base.Timeout = d
+	}
+	if hc.HealthyThreshold > 0 {
+		base.HealthyThreshold = hc.HealthyThreshold
+	}
+	if hc.UnhealthyThreshold > 0 {
+		base.UnhealthyThreshold = hc.UnhealthyThreshold
+	}
+	return base
+}
+
+// ApplyConfig wires cfg's backend set into the running servers. On initial
+// load (initial=true) it just adds every backend. On a reload it diffs
+// against the currently running backends: new ones are added and
+// pre-warmed, removed ones are drained and dropped in the background, and
+// ones present in both get their weight updated live - all without
+// dropping in-flight requests. Capacity changes on an existing backend are
+// logged but not applied, since resizing its connection pool would require
+// recreating it.
+func ApplyConfig(cfg *Config, initial bool) error {
+	policy, err := NewSelectionPolicy(cfg.SelectionPolicy)
+	if err != nil {
+		return err
+	}
+	servers.SetPolicy(policy)
+
+	retryPolicy.Set(cfg.RetryPolicy.RetryPolicy(retryPolicy.Get()))
+	baseHealthCheck := cfg.HealthCheck.HealthCheckConfig(DefaultHealthCheckConfig())
+
+	desired := make(map[string]BackendConfig, len(cfg.Backends))
+	for _, b := range cfg.Backends {
+		u, err := url.Parse(b.URL)
+		if err != nil {
+			return fmt.Errorf("backend %q: %w", b.URL, err)
+		}
+		desired[u.String()] = b
+	}
+
+	existing := servers.List()
+	existingByURL := make(map[string]*Backend, len(existing))
+	for _, b := range existing {
+		existingByURL[b.URL.String()] = b
+	}
+
+	for key, backend := range existingByURL {
+		if _, stillWanted := desired[key]; !stillWanted {
+			go func(backend *Backend) {
+				backend.Drain(30 * time.Second)
+				servers.RemoveBackend(backend.URL)
+				log.Printf("[%s] removed by config reload", backend.URL.Host)
+			}(backend)
+		}
+	}
+
+	for key, b := range desired {
+		hcConfig := HealthCheckConfigFile{Path: b.HealthPath}.HealthCheckConfig(baseHealthCheck)
+		if existingBackend, ok := existingByURL[key]; ok {
+			existingBackend.SetWeight(b.Weight)
+			if existingBackend.capacity != b.Capacity && b.Capacity > 0 {
+				log.Printf("[%s] capacity change from %d to %d requires removing and re-adding the backend", existingBackend.URL.Host, existingBackend.capacity, b.Capacity)
+			}
+			continue
+		}
+		u, _ := url.Parse(b.URL)
+		backend := newConfiguredBackend(u, b.Capacity, b.Weight, hcConfig)
+		servers.AddBackend(backend)
+		go PrewarmPool(httpClient, backend, prewarmConns)
+		if initial {
+			log.Printf("Configured server: %s\n", u)
+		} else {
+			log.Printf("[%s] added by config reload", u.Host)
+		}
+	}
+
+	return nil
+}
+
+// watchForReload reloads the config file from path whenever the process
+// receives SIGHUP, diffing the backend set in place.
+func watchForReload(path string) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	for range sigCh {
+		log.Printf("SIGHUP received, reloading config from %s", path)
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			log.Printf("config reload failed: %v", err)
+			continue
+		}
+		if err := ApplyConfig(cfg, false); err != nil {
+			log.Printf("config reload failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthState is the current verdict of a backend's HealthChecker.
+type HealthState int
+
+const (
+	// StateUnknown is the state of a backend that hasn't completed a
+	// health check yet.
+	StateUnknown HealthState = iota
+	StateHealthy
+	StateUnhealthy
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case StateHealthy:
+		return "healthy"
+	case StateUnhealthy:
+		return "unhealthy"
+	default:
+		return "unknown"
+	}
+}
+
+// HealthCheckConfig configures the active HTTP health checker for a backend.
+type HealthCheckConfig struct {
+	Path               string
+	Method             string
+	ExpectedStatus     []int
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int // consecutive successes needed to go healthy
+	UnhealthyThreshold int // consecutive failures needed to go unhealthy
+}
+
+// DefaultHealthCheckConfig returns sane defaults: GET /, 200 OK, checked
+// every 10s with a 2s timeout, 2 consecutive results to flip state.
+func DefaultHealthCheckConfig() HealthCheckConfig {
+	return HealthCheckConfig{
+		Path:               "/",
+		Method:             http.MethodGet,
+		ExpectedStatus:     []int{http.StatusOK},
+		Interval:           10 * time.Second,
+		Timeout:            2 * time.Second,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 2,
+	}
+}
+
+// HealthChecker runs active HTTP health checks against a single backend and
+// tracks a healthy/unhealthy state machine. Passive failures observed by the
+// proxy (e.g. connection errors) feed the same state machine through
+// ReportFailure, so a flood of passive failures and a failing active check
+// agree on when a backend flips. ReportSuccess feeds a passive success the
+// same way; the proxy path does not call it.
+//
+// All state is guarded by mu; Start runs the active probe loop in its own
+// goroutine and stops when ctx is cancelled.
+type HealthChecker struct {
+	backend *Backend
+	cfg     HealthCheckConfig
+	client  *http.Client
+
+	mu        sync.Mutex
+	state     HealthState
+	successes int
+	failures  int
+	cancel    context.CancelFunc
+}
+
+// NewHealthChecker builds a checker for backend using cfg. It does not start
+// probing until Start is called.
+func NewHealthChecker(backend *Backend, cfg HealthCheckConfig) *HealthChecker {
+	return &HealthChecker{
+		backend: backend,
+		cfg:     cfg,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: &http.Transport{},
+		},
+		state: StateUnknown,
+	}
+}
+
+// Start launches the active probe loop. It returns immediately; the loop
+// runs until ctx is cancelled or Stop is called.
+func (hc *HealthChecker) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	hc.mu.Lock()
+	hc.cancel = cancel
+	hc.mu.Unlock()
+	go hc.run(ctx)
+}
+
+// Stop cancels the active probe loop started by Start. Called when a
+// backend is removed (admin API or config reload) so its checker goroutine,
+// ticker and HTTP client don't leak and keep polling a backend nobody
+// routes to anymore. Safe to call even if Start was never called.
+func (hc *HealthChecker) Stop() {
+	hc.mu.Lock()
+	cancel := hc.cancel
+	hc.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (hc *HealthChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(hc.cfg.Interval)
+	defer ticker.Stop()
+	hc.probeOnce(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hc.probeOnce(ctx)
+		}
+	}
+}
+
+func (hc *HealthChecker) probeOnce(ctx context.Context) {
+	reqCtx, cancel := context.WithTimeout(ctx, hc.cfg.Timeout)
+	defer cancel()
+
+	u := *hc.backend.URL
+	u.Path = hc.cfg.Path
+	req, err := http.NewRequestWithContext(reqCtx, hc.cfg.Method, u.String(), nil)
+	if err != nil {
+		log.Printf("[%s] health check request build failed: %v", hc.backend.URL.Host, err)
+		hc.record(false)
+		return
+	}
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		hc.record(false)
+		return
+	}
+	defer resp.Body.Close()
+
+	hc.record(hc.isExpectedStatus(resp.StatusCode))
+}
+
+func (hc *HealthChecker) isExpectedStatus(status int) bool {
+	for _, s := range hc.cfg.ExpectedStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportSuccess feeds a passive success (a proxied request completed fine)
+// into the same state machine the active checks use.
+func (hc *HealthChecker) ReportSuccess() {
+	hc.record(true)
+}
+
+// ReportFailure feeds a passive failure (a proxy error) into the same state
+// machine the active checks use.
+func (hc *HealthChecker) ReportFailure() {
+	hc.record(false)
+}
+
+func (hc *HealthChecker) record(success bool) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if success {
+		hc.successes++
+		hc.failures = 0
+		if hc.state != StateHealthy && hc.successes >= hc.cfg.HealthyThreshold {
+			hc.transition(StateHealthy)
+		}
+	} else {
+		hc.failures++
+		hc.successes = 0
+		if hc.state != StateUnhealthy && hc.failures >= hc.cfg.UnhealthyThreshold {
+			hc.transition(StateUnhealthy)
+		}
+	}
+}
+
+// transition must be called with mu held.
+func (hc *HealthChecker) transition(state HealthState) {
+	log.Printf("[%s] health check state transition: %s -> %s", hc.backend.URL.Host, hc.state, state)
+	metrics.ObserveHealthCheck(hc.backend.URL.Host, state.String())
+	hc.state = state
+	hc.backend.SetAlive(state == StateHealthy)
+}
+
+// Recheck forces an immediate probe, outside of the regular interval. Used
+// by the admin API's force-recheck endpoint.
+func (hc *HealthChecker) Recheck(ctx context.Context) {
+	hc.probeOnce(ctx)
+}
+
+// State returns the checker's current verdict, for status/metrics endpoints.
+func (hc *HealthChecker) State() HealthState {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hc.state
+}
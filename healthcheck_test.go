@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+func testHealthChecker(t *testing.T, healthyThreshold, unhealthyThreshold int) (*Backend, *HealthChecker) {
+	t.Helper()
+	backend := testBackend(t, "checked", 1, 1)
+	cfg := DefaultHealthCheckConfig()
+	cfg.HealthyThreshold = healthyThreshold
+	cfg.UnhealthyThreshold = unhealthyThreshold
+	hc := NewHealthChecker(backend, cfg)
+	backend.checker = hc
+	return backend, hc
+}
+
+func TestHealthCheckerFlipsUnhealthyAtThreshold(t *testing.T) {
+	backend, hc := testHealthChecker(t, 2, 2)
+	backend.SetAlive(true)
+
+	hc.ReportFailure()
+	if !backend.IsAlive() {
+		t.Fatal("backend went down after only 1 failure, want it to stay up below UnhealthyThreshold")
+	}
+	hc.ReportFailure()
+	if hc.State() != StateUnhealthy {
+		t.Fatalf("state = %s, want unhealthy after 2 consecutive failures", hc.State())
+	}
+	if backend.IsAlive() {
+		t.Fatal("backend should be marked down once the unhealthy threshold is reached")
+	}
+}
+
+func TestHealthCheckerFlipsHealthyAtThreshold(t *testing.T) {
+	backend, hc := testHealthChecker(t, 2, 1)
+	hc.ReportFailure() // one failure is enough to go unhealthy with threshold 1
+	if backend.IsAlive() {
+		t.Fatal("backend should be down after crossing UnhealthyThreshold")
+	}
+
+	hc.ReportSuccess()
+	if backend.IsAlive() || hc.State() != StateUnhealthy {
+		t.Fatal("backend should stay down after only 1 success, want 2 (HealthyThreshold)")
+	}
+
+	hc.ReportSuccess()
+	if hc.State() != StateHealthy || !backend.IsAlive() {
+		t.Fatalf("state = %s, alive = %v, want healthy/true after 2 consecutive successes", hc.State(), backend.IsAlive())
+	}
+}
+
+func TestHealthCheckerFailureResetsSuccessStreak(t *testing.T) {
+	backend, hc := testHealthChecker(t, 2, 2)
+
+	hc.ReportSuccess() // successes=1
+	hc.ReportFailure() // should reset the success streak, not leave it at 1
+	hc.ReportSuccess() // successes=1 again, not yet at HealthyThreshold
+	if hc.State() == StateHealthy {
+		t.Fatal("went healthy after only 1 success since the last failure; ReportFailure should reset the success streak")
+	}
+
+	hc.ReportSuccess() // successes=2, now at HealthyThreshold
+	if hc.State() != StateHealthy || !backend.IsAlive() {
+		t.Fatalf("state = %s, alive = %v, want healthy/true after 2 consecutive successes", hc.State(), backend.IsAlive())
+	}
+}
+
+func TestHealthCheckerRepeatedSuccessesWhileHealthyAreNoOp(t *testing.T) {
+	backend, hc := testHealthChecker(t, 1, 2)
+	hc.ReportSuccess()
+	if hc.State() != StateHealthy || !backend.IsAlive() {
+		t.Fatalf("state = %s, alive = %v, want healthy/true after crossing HealthyThreshold(1)", hc.State(), backend.IsAlive())
+	}
+	// Further successes while already healthy must not panic or regress state.
+	hc.ReportSuccess()
+	hc.ReportSuccess()
+	if hc.State() != StateHealthy || !backend.IsAlive() {
+		t.Fatal("extra successes while already healthy should be a no-op, not flip state")
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// requestLogger emits one structured JSON event per proxied request,
+// instead of the old log.Printf/fmt.Println lines that were scattered
+// across lb and hard to parse in aggregate.
+var requestLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// statusRecorder wraps a ResponseWriter to capture the status code the
+// backend responded with, since httputil.ReverseProxy writes straight to
+// the ResponseWriter without giving the caller a chance to observe it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
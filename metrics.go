@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics accumulates the counters exposed at /metrics, in Prometheus's
+// text exposition format. There's no vendored Prometheus client here, so
+// it's hand-rolled: plain maps guarded by a mutex, which is plenty for the
+// cardinality a handful of backends produces.
+//
+// requestDurationSum/requestDurationCount expose latency as a Prometheus
+// summary (sum and count only, no quantiles) rather than a real histogram -
+// a proper le-bucketed histogram that supports histogram_quantile would
+// need per-bucket counters (and a set of bucket boundaries to pick), which
+// felt like more surface than this hand-rolled exporter should take on. If
+// that's needed later, add a requestDurationBucketCounts map keyed by
+// metricKey(backend, le) and a loadbalancer_request_duration_seconds_bucket
+// series.
+type Metrics struct {
+	mu sync.Mutex
+
+	requestsTotal        map[string]uint64  // metricKey(backend, method, status) -> count
+	requestDurationSum   map[string]float64 // "backend" -> seconds
+	requestDurationCount map[string]uint64  // "backend" -> count
+	healthChecksTotal    map[string]uint64  // metricKey(backend, outcome) -> count
+	retriesTotal         map[string]uint64  // "backend" -> count
+	selectionTotal       map[string]uint64  // metricKey(policy, backend) -> count
+}
+
+// NewMetrics returns an empty Metrics ready to record observations.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requestsTotal:        make(map[string]uint64),
+		requestDurationSum:   make(map[string]float64),
+		requestDurationCount: make(map[string]uint64),
+		healthChecksTotal:    make(map[string]uint64),
+		retriesTotal:         make(map[string]uint64),
+		selectionTotal:       make(map[string]uint64),
+	}
+}
+
+var metrics = NewMetrics()
+
+func metricKey(parts ...string) string {
+	return strings.Join(parts, "\x1f")
+}
+
+// ObserveRequest records one proxied request: its outcome (backend, method,
+// status) and its upstream latency.
+func (m *Metrics) ObserveRequest(backend, method string, status int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requestsTotal[metricKey(backend, method, fmt.Sprint(status))]++
+	m.requestDurationSum[backend] += duration.Seconds()
+	m.requestDurationCount[backend]++
+}
+
+// ObserveHealthCheck records a health check state transition's outcome
+// (e.g. "healthy"/"unhealthy") for backend.
+func (m *Metrics) ObserveHealthCheck(backend, outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.healthChecksTotal[metricKey(backend, outcome)]++
+}
+
+// ObserveRetry records one retry attempt against backend.
+func (m *Metrics) ObserveRetry(backend string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retriesTotal[backend]++
+}
+
+// ObserveSelection records that policy chose backend for a request.
+func (m *Metrics) ObserveSelection(policy, backend string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.selectionTotal[metricKey(policy, backend)]++
+}
+
+// WriteTo renders every metric in Prometheus text exposition format,
+// plus a live in-flight gauge read straight from the current backends.
+func (m *Metrics) WriteTo(w io.Writer, backends []*Backend) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP loadbalancer_requests_total Total proxied requests by backend, method and status.")
+	fmt.Fprintln(w, "# TYPE loadbalancer_requests_total counter")
+	for _, k := range sortedKeys(m.requestsTotal) {
+		parts := strings.Split(k, "\x1f")
+		fmt.Fprintf(w, "loadbalancer_requests_total{backend=%q,method=%q,status=%q} %d\n", parts[0], parts[1], parts[2], m.requestsTotal[k])
+	}
+
+	fmt.Fprintln(w, "# HELP loadbalancer_request_duration_seconds Upstream latency by backend, as sum+count only (no le buckets, not queryable with histogram_quantile).")
+	fmt.Fprintln(w, "# TYPE loadbalancer_request_duration_seconds summary")
+	for _, backend := range sortedKeys(m.requestDurationCount) {
+		fmt.Fprintf(w, "loadbalancer_request_duration_seconds_sum{backend=%q} %f\n", backend, m.requestDurationSum[backend])
+		fmt.Fprintf(w, "loadbalancer_request_duration_seconds_count{backend=%q} %d\n", backend, m.requestDurationCount[backend])
+	}
+
+	fmt.Fprintln(w, "# HELP loadbalancer_in_flight_requests Requests currently being proxied, by backend.")
+	fmt.Fprintln(w, "# TYPE loadbalancer_in_flight_requests gauge")
+	for _, b := range backends {
+		fmt.Fprintf(w, "loadbalancer_in_flight_requests{backend=%q} %d\n", b.URL.Host, b.Flow())
+	}
+
+	fmt.Fprintln(w, "# HELP loadbalancer_pool_idle_slots Free capacity slots in a backend's connection pool.")
+	fmt.Fprintln(w, "# TYPE loadbalancer_pool_idle_slots gauge")
+	for _, b := range backends {
+		fmt.Fprintf(w, "loadbalancer_pool_idle_slots{backend=%q} %d\n", b.URL.Host, b.PoolMetrics().Idle)
+	}
+
+	fmt.Fprintln(w, "# HELP loadbalancer_health_checks_total Health check outcomes by backend.")
+	fmt.Fprintln(w, "# TYPE loadbalancer_health_checks_total counter")
+	for _, k := range sortedKeys(m.healthChecksTotal) {
+		parts := strings.Split(k, "\x1f")
+		fmt.Fprintf(w, "loadbalancer_health_checks_total{backend=%q,outcome=%q} %d\n", parts[0], parts[1], m.healthChecksTotal[k])
+	}
+
+	fmt.Fprintln(w, "# HELP loadbalancer_retries_total Retries attempted against a backend.")
+	fmt.Fprintln(w, "# TYPE loadbalancer_retries_total counter")
+	for _, backend := range sortedKeys(m.retriesTotal) {
+		fmt.Fprintf(w, "loadbalancer_retries_total{backend=%q} %d\n", backend, m.retriesTotal[backend])
+	}
+
+	fmt.Fprintln(w, "# HELP loadbalancer_selection_decisions_total Times a selection policy chose a backend.")
+	fmt.Fprintln(w, "# TYPE loadbalancer_selection_decisions_total counter")
+	for _, k := range sortedKeys(m.selectionTotal) {
+		parts := strings.Split(k, "\x1f")
+		fmt.Fprintf(w, "loadbalancer_selection_decisions_total{policy=%q,backend=%q} %d\n", parts[0], parts[1], m.selectionTotal[k])
+	}
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// SelectionPolicy picks which backend should handle the next request.
+//
+// Select receives a snapshot of the currently alive backends; it must not
+// mutate the slice. Implementations that keep their own state (e.g. a
+// round-robin cursor) are responsible for guarding it against concurrent
+// calls, since Select may be called from many goroutines at once.
+type SelectionPolicy interface {
+	Name() string
+	Select(r *http.Request, backends []*Backend) *Backend
+}
+
+// NewSelectionPolicy builds a SelectionPolicy by name, for use with a
+// config/CLI flag (e.g. "-policy weighted-round-robin").
+func NewSelectionPolicy(name string) (SelectionPolicy, error) {
+	switch name {
+	case "", "round-robin":
+		return &RoundRobinPolicy{}, nil
+	case "weighted-round-robin":
+		return &WeightedRoundRobinPolicy{}, nil
+	case "least-connections":
+		return &LeastConnectionsPolicy{}, nil
+	case "random":
+		return &RandomPolicy{}, nil
+	case "ip-hash":
+		return &IPHashPolicy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown selection policy %q", name)
+	}
+}
+
+// RoundRobinPolicy cycles through backends in order. Its cursor is an
+// atomic counter, so Select is safe for concurrent use.
+type RoundRobinPolicy struct {
+	current uint64
+}
+
+func (p *RoundRobinPolicy) Name() string { return "round-robin" }
+
+func (p *RoundRobinPolicy) Select(r *http.Request, backends []*Backend) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	idx := atomic.AddUint64(&p.current, 1) % uint64(len(backends))
+	return backends[idx]
+}
+
+// WeightedRoundRobinPolicy favours backends with a higher Backend.weight,
+// using the smooth weighted round-robin algorithm (as used by nginx): each
+// backend accumulates its weight every round, and the one with the highest
+// accumulated value is picked and then penalised by the total weight. The
+// per-backend accumulators are guarded by a mutex since they're shared
+// state mutated on every Select. Select also prunes accumulators for
+// backends not in its current argument, so backends removed at runtime
+// (admin API, config reload) don't leak map entries for the life of the
+// process.
+type WeightedRoundRobinPolicy struct {
+	mu    sync.Mutex
+	state map[*Backend]int
+}
+
+func (p *WeightedRoundRobinPolicy) Name() string { return "weighted-round-robin" }
+
+func (p *WeightedRoundRobinPolicy) Select(r *http.Request, backends []*Backend) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state == nil {
+		p.state = make(map[*Backend]int, len(backends))
+	}
+
+	present := make(map[*Backend]bool, len(backends))
+	var best *Backend
+	total := 0
+	for _, b := range backends {
+		present[b] = true
+		weight := b.weight
+		if weight <= 0 {
+			weight = 1
+		}
+		total += weight
+		p.state[b] += weight
+		if best == nil || p.state[b] > p.state[best] {
+			best = b
+		}
+	}
+	p.state[best] -= total
+
+	for b := range p.state {
+		if !present[b] {
+			delete(p.state, b)
+		}
+	}
+	return best
+}
+
+// LeastConnectionsPolicy picks the backend with the fewest in-flight
+// requests (Backend.flow). Select only reads each backend's flow via its
+// own accessor, so it needs no extra locking of its own.
+type LeastConnectionsPolicy struct{}
+
+func (p *LeastConnectionsPolicy) Name() string { return "least-connections" }
+
+func (p *LeastConnectionsPolicy) Select(r *http.Request, backends []*Backend) *Backend {
+	var best *Backend
+	var bestFlow uint64
+	for _, b := range backends {
+		flow := b.Flow()
+		if best == nil || flow < bestFlow {
+			best = b
+			bestFlow = flow
+		}
+	}
+	return best
+}
+
+// RandomPolicy picks a uniformly random backend.
+type RandomPolicy struct{}
+
+func (p *RandomPolicy) Name() string { return "random" }
+
+func (p *RandomPolicy) Select(r *http.Request, backends []*Backend) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	return backends[rand.Intn(len(backends))]
+}
+
+// IPHashPolicy hashes the client's address so the same client keeps hitting
+// the same backend (session affinity), as long as the backend set doesn't
+// change. It keeps no state of its own, so Select is inherently safe for
+// concurrent use.
+type IPHashPolicy struct{}
+
+func (p *IPHashPolicy) Name() string { return "ip-hash" }
+
+func (p *IPHashPolicy) Select(r *http.Request, backends []*Backend) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	host := r.RemoteAddr
+	if idx := strings.LastIndex(host, ":"); idx != -1 {
+		host = host[:idx]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	idx := h.Sum32() % uint32(len(backends))
+	return backends[idx]
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func testBackend(t *testing.T, host string, capacity, weight int) *Backend {
+	t.Helper()
+	u, err := url.Parse("http://" + host)
+	if err != nil {
+		t.Fatalf("parsing test backend url: %v", err)
+	}
+	return NewBackend(u, nil, capacity, weight)
+}
+
+func TestRoundRobinPolicyCyclesInOrder(t *testing.T) {
+	backends := []*Backend{
+		testBackend(t, "a", 1, 1),
+		testBackend(t, "b", 1, 1),
+		testBackend(t, "c", 1, 1),
+	}
+	p := &RoundRobinPolicy{}
+
+	var got []string
+	for i := 0; i < len(backends)*2; i++ {
+		got = append(got, p.Select(nil, backends).URL.Host)
+	}
+	want := []string{"b", "c", "a", "b", "c", "a"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("pick %d: got %q, want %q (full sequence %v)", i, got[i], want[i], got)
+		}
+	}
+}
+
+func TestRoundRobinPolicyEmptyBackends(t *testing.T) {
+	p := &RoundRobinPolicy{}
+	if b := p.Select(nil, nil); b != nil {
+		t.Fatalf("Select on empty backends = %v, want nil", b)
+	}
+}
+
+func TestWeightedRoundRobinPolicyFavoursHigherWeight(t *testing.T) {
+	backends := []*Backend{
+		testBackend(t, "heavy", 1, 3),
+		testBackend(t, "light", 1, 1),
+	}
+	p := &WeightedRoundRobinPolicy{}
+
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		counts[p.Select(nil, backends).URL.Host]++
+	}
+	if counts["heavy"] != 6 || counts["light"] != 2 {
+		t.Fatalf("got counts %v, want heavy=6 light=2 over 8 picks (smooth WRR, weights 3:1)", counts)
+	}
+}
+
+func TestWeightedRoundRobinPolicyPrunesRemovedBackends(t *testing.T) {
+	p := &WeightedRoundRobinPolicy{}
+
+	for i := 0; i < 50; i++ {
+		b := testBackend(t, "churned", 1, 1)
+		p.Select(nil, []*Backend{b})
+	}
+
+	if len(p.state) > 1 {
+		t.Fatalf("state has %d entries after 50 one-off backends, want stale entries pruned (at most the last backend)", len(p.state))
+	}
+}
+
+func TestLeastConnectionsPolicyPicksFewestFlow(t *testing.T) {
+	busy := testBackend(t, "busy", 10, 1)
+	idle := testBackend(t, "idle", 10, 1)
+	busy.TryAcquire()
+	busy.TryAcquire()
+	idle.TryAcquire()
+
+	p := &LeastConnectionsPolicy{}
+	got := p.Select(nil, []*Backend{busy, idle})
+	if got != idle {
+		t.Fatalf("Select() = %s, want idle (flow 1 < busy's flow 2)", got.URL.Host)
+	}
+}
+
+func TestIPHashPolicyIsStableAndSpreads(t *testing.T) {
+	backends := []*Backend{
+		testBackend(t, "a", 1, 1),
+		testBackend(t, "b", 1, 1),
+		testBackend(t, "c", 1, 1),
+	}
+	p := &IPHashPolicy{}
+
+	r1 := &http.Request{RemoteAddr: "10.0.0.1:54321"}
+	first := p.Select(r1, backends)
+	for i := 0; i < 5; i++ {
+		r := &http.Request{RemoteAddr: "10.0.0.1:9999"}
+		if got := p.Select(r, backends); got != first {
+			t.Fatalf("same client IP on different ports got different backends: %s vs %s", got.URL.Host, first.URL.Host)
+		}
+	}
+
+	// Any two client IPs may collide with only 3 backends, but if every one
+	// of a spread of IPs lands on the same backend the policy is ignoring
+	// RemoteAddr entirely.
+	seen := map[*Backend]bool{}
+	for i := 1; i <= 20; i++ {
+		r := &http.Request{RemoteAddr: fmt.Sprintf("10.0.0.%d:1111", i)}
+		seen[p.Select(r, backends)] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("20 different client IPs all hashed to the same backend, want them spread over more than one")
+	}
+}
+
+func TestNewSelectionPolicyUnknownName(t *testing.T) {
+	if _, err := NewSelectionPolicy("does-not-exist"); err == nil {
+		t.Fatal("NewSelectionPolicy with an unknown name should return an error")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TransportConfig configures the *http.Transport shared by every backend's
+// reverse proxy, so keep-alive connections are pooled consistently across
+// the whole balancer instead of each proxy getting http.DefaultTransport.
+type TransportConfig struct {
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	TLSHandshakeTimeout time.Duration
+	DialTimeout         time.Duration
+}
+
+// DefaultTransportConfig returns conservative defaults suitable for a
+// handful of backends with moderate concurrency.
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		MaxIdleConnsPerHost: 32,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+		DialTimeout:         5 * time.Second,
+	}
+}
+
+// NewTransport builds the shared *http.Transport from cfg.
+func NewTransport(cfg TransportConfig) *http.Transport {
+	return &http.Transport{
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:     cfg.IdleConnTimeout,
+		TLSHandshakeTimeout: cfg.TLSHandshakeTimeout,
+		DialContext: (&net.Dialer{
+			Timeout: cfg.DialTimeout,
+		}).DialContext,
+	}
+}
+
+// PrewarmPool establishes n keep-alive connections to backend ahead of
+// time, by firing n concurrent HEAD requests through client and discarding
+// the responses, so the transport's idle pool is already populated before
+// real traffic arrives.
+func PrewarmPool(client *http.Client, backend *Backend, n int) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodHead, backend.URL.String(), nil)
+			if err != nil {
+				return
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+// PoolMetrics is a snapshot of a backend's in-flight request governor.
+type PoolMetrics struct {
+	Idle  int
+	InUse int
+}
+
+// TryAcquire claims one of the backend's capacity slots without blocking.
+// It returns false if the backend is already at capacity (full), in which
+// case the caller should treat the backend as unavailable for this request
+// rather than as down.
+func (b *Backend) TryAcquire() bool {
+	select {
+	case b.sem <- struct{}{}:
+		b.Lock()
+		b.flow++
+		b.Unlock()
+		return true
+	default:
+		return false
+	}
+}
+
+// Release frees up the capacity slot claimed by TryAcquire.
+func (b *Backend) Release() {
+	b.Lock()
+	if b.flow > 0 {
+		b.flow--
+	}
+	b.Unlock()
+	select {
+	case <-b.sem:
+	default:
+	}
+}
+
+// IsFull reports whether the backend is currently handling as many requests
+// as its capacity allows.
+func (b *Backend) IsFull() bool {
+	b.Lock()
+	full := b.flow >= uint64(b.capacity)
+	b.Unlock()
+	return full
+}
+
+// PoolMetrics reports the backend's current idle/in-use capacity slots.
+func (b *Backend) PoolMetrics() PoolMetrics {
+	b.Lock()
+	defer b.Unlock()
+	idle := b.capacity - int(b.flow)
+	if idle < 0 {
+		idle = 0
+	}
+	return PoolMetrics{
+		Idle:  idle,
+		InUse: int(b.flow),
+	}
+}
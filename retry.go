@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// contextKey is an unexported type so the request-scoped values below can't
+// collide with keys set by other packages (or string literals elsewhere in
+// this one).
+type contextKey int
+
+const (
+	attemptsContextKey contextKey = iota
+	retryContextKey
+	tryDeadlineContextKey
+)
+
+// RetryPolicy controls how lb retries a failing request: how many backends
+// it will try, the total wall-clock budget across all of them, the backoff
+// between attempts against the same backend, and which requests are safe to
+// retry at all.
+type RetryPolicy struct {
+	MaxRetries           int           // backends to try before giving up
+	TryDuration          time.Duration // total wall-clock budget for the whole request
+	TryInterval          time.Duration // backoff between attempts against the same backend
+	RetryableStatusCodes map[int]bool
+	RetryableMethods     map[string]bool // methods safe to retry; GET/HEAD by default
+}
+
+// DefaultRetryPolicy mirrors Caddy's reverse_proxy defaults: a handful of
+// retries, a couple of seconds of total budget, and only idempotent methods
+// retried automatically.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:  3,
+		TryDuration: 2 * time.Second,
+		TryInterval: 10 * time.Millisecond,
+		RetryableStatusCodes: map[int]bool{
+			http.StatusBadGateway:         true,
+			http.StatusServiceUnavailable: true,
+			http.StatusGatewayTimeout:     true,
+		},
+		RetryableMethods: map[string]bool{
+			http.MethodGet:  true,
+			http.MethodHead: true,
+		},
+	}
+}
+
+// IsRetryableMethod reports whether r's method is allowed to be retried.
+func (p RetryPolicy) IsRetryableMethod(method string) bool {
+	return p.RetryableMethods[method]
+}
+
+// IsRetryableStatus reports whether a backend response with this status
+// should be retried, first against the same backend and then against
+// another one.
+func (p RetryPolicy) IsRetryableStatus(status int) bool {
+	return p.RetryableStatusCodes[status]
+}
+
+// retryPolicyHolder guards the live RetryPolicy. It's read on every request
+// goroutine (lb, DefaultErrorHandler) and written from the SIGHUP reload
+// goroutine (ApplyConfig) and main's flag wiring, so plain field access on a
+// shared *RetryPolicy would race the same way the old unguarded Servers
+// slice did.
+type retryPolicyHolder struct {
+	mu     sync.RWMutex
+	policy RetryPolicy
+}
+
+// newRetryPolicyHolder wraps an initial RetryPolicy for concurrent access.
+func newRetryPolicyHolder(p RetryPolicy) *retryPolicyHolder {
+	return &retryPolicyHolder{policy: p}
+}
+
+// Get returns a copy of the current RetryPolicy.
+func (h *retryPolicyHolder) Get() RetryPolicy {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.policy
+}
+
+// Set replaces the current RetryPolicy, e.g. on a config reload.
+func (h *retryPolicyHolder) Set(p RetryPolicy) {
+	h.mu.Lock()
+	h.policy = p
+	h.mu.Unlock()
+}
+
+// RetryBudget is a token-bucket limiter shared across all in-flight
+// requests. Each retry attempt consumes a token; tokens refill over time.
+// This keeps a mass backend failure from turning every client's retries
+// into a retry storm that overwhelms whatever backends are still up.
+type RetryBudget struct {
+	mu              sync.Mutex
+	tokens          float64
+	max             float64
+	refillPerSecond float64
+	last            time.Time
+}
+
+// NewRetryBudget creates a budget holding up to max tokens, refilled at
+// refillPerSecond tokens/second.
+func NewRetryBudget(max int, refillPerSecond float64) *RetryBudget {
+	return &RetryBudget{
+		tokens:          float64(max),
+		max:             float64(max),
+		refillPerSecond: refillPerSecond,
+		last:            time.Now(),
+	}
+}
+
+// Allow consumes a token if one is available and reports whether it did.
+func (b *RetryBudget) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.refillPerSecond
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func withAttempts(ctx context.Context, attempts int) context.Context {
+	return context.WithValue(ctx, attemptsContextKey, attempts)
+}
+
+// AttemptsFromContext returns how many different backends this request has
+// already been tried against.
+func AttemptsFromContext(ctx context.Context) int {
+	if attempts, ok := ctx.Value(attemptsContextKey).(int); ok {
+		return attempts
+	}
+	return 0
+}
+
+func withRetry(ctx context.Context, retries int) context.Context {
+	return context.WithValue(ctx, retryContextKey, retries)
+}
+
+// RetryFromContext returns how many times the current backend has already
+// been retried for this request.
+func RetryFromContext(ctx context.Context) int {
+	if retries, ok := ctx.Value(retryContextKey).(int); ok {
+		return retries
+	}
+	return 0
+}
+
+func withTryDeadline(ctx context.Context, deadline time.Time) context.Context {
+	return context.WithValue(ctx, tryDeadlineContextKey, deadline)
+}
+
+// TryDeadlineFromContext returns the wall-clock deadline for the whole
+// request (across every backend it's retried against), if one has been set.
+func TryDeadlineFromContext(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Value(tryDeadlineContextKey).(time.Time)
+	return deadline, ok
+}
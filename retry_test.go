@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDefaultRetryPolicyRetryableStatusAndMethod(t *testing.T) {
+	p := DefaultRetryPolicy()
+
+	for _, status := range []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		if !p.IsRetryableStatus(status) {
+			t.Errorf("IsRetryableStatus(%d) = false, want true", status)
+		}
+	}
+	if p.IsRetryableStatus(http.StatusOK) {
+		t.Error("IsRetryableStatus(200) = true, want false")
+	}
+
+	if !p.IsRetryableMethod(http.MethodGet) || !p.IsRetryableMethod(http.MethodHead) {
+		t.Error("GET/HEAD should be retryable by default")
+	}
+	if p.IsRetryableMethod(http.MethodPost) {
+		t.Error("POST should not be retryable by default (opt-in only)")
+	}
+}
+
+func TestRetryBudgetDeniesOnceExhausted(t *testing.T) {
+	b := NewRetryBudget(3, 0)
+
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Fatalf("Allow() #%d = false, want true (budget should hold 3 tokens)", i)
+		}
+	}
+	if b.Allow() {
+		t.Fatal("Allow() after exhausting the budget = true, want false")
+	}
+}
+
+func TestRetryBudgetRefillsOverTime(t *testing.T) {
+	b := NewRetryBudget(1, 1000) // refills fast enough that a short sleep is plenty
+
+	if !b.Allow() {
+		t.Fatal("Allow() on a fresh budget = false, want true")
+	}
+	if b.Allow() {
+		t.Fatal("Allow() right after exhausting a 1-token budget = true, want false")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("Allow() after waiting for refill = false, want true")
+	}
+}